@@ -3,37 +3,211 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	origLog "log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	FeatureNone = iota
 	FeatureSelect
 	FeatureDelayTest
+	FeatureDelayTestAll
+)
+
+const (
+	ExitOK              = 0
+	ExitBadInput        = 2
+	ExitControllerError = 3
+	ExitTimeout         = 4
 )
 
 var in = bufio.NewReader(os.Stdin)
 var log = origLog.New(os.Stderr, "", 0)
 
 type Config struct {
-	Port    *int // Nullable
-	Addr    string
-	Scheme  string
-	Groups  []string
-	TestURL string
+	Port           *int // Nullable
+	Addr           string
+	Scheme         string
+	Groups         []string
+	TestURL        string
+	Concurrency    int
+	MaxDelay       int
+	ExpectedStatus string
+	AutoSelect     bool
+	Filter         *regexp.Regexp
+	ExcludeFilter  *regexp.Regexp
+	ExcludeType    map[string]bool
+	Secret         string
+	Insecure       bool
+	CAFile         string
+	JSONOutput     bool
+	Node           string
+	ListOnly       bool
+	Favorites      map[string][]string
+}
+
+type Profile struct {
+	Port      *int                `yaml:"port,omitempty"`
+	Addr      string              `yaml:"addr,omitempty"`
+	Scheme    string              `yaml:"scheme,omitempty"`
+	Secret    string              `yaml:"secret,omitempty"`
+	TestURL   string              `yaml:"test-url,omitempty"`
+	Groups    []string            `yaml:"groups,omitempty"`
+	Filter    string              `yaml:"filter,omitempty"`
+	Favorites map[string][]string `yaml:"favorites,omitempty"`
+}
+
+type ConfigFile struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "clashcli", "config.yaml")
+}
+
+func resolveConfigPath(flagVal string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	if envVal := os.Getenv("CLASHCLI_CONFIG"); envVal != "" {
+		return envVal
+	}
+	return defaultConfigPath()
+}
+
+func loadConfigFile(path string) (*ConfigFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cf := new(ConfigFile)
+	if err := yaml.Unmarshal(data, cf); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func doProfilesCommand(configPathFlag string) {
+	configPath := resolveConfigPath(configPathFlag)
+	cf, err := loadConfigFile(configPath)
+	if err != nil {
+		log.Panicf("Can't load config file %s: %s\n", configPath, err.Error())
+	}
+	if cf == nil || len(cf.Profiles) == 0 {
+		fmt.Printf("No profiles configured in %s\n", configPath)
+		return
+	}
+
+	names := make([]string, 0, len(cf.Profiles))
+	for name := range cf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Profiles in %s:\n\n", configPath)
+	for _, name := range names {
+		p := cf.Profiles[name]
+		portPrint := "<Not decided>"
+		if p.Port != nil {
+			portPrint = fmt.Sprintf("%d", *p.Port)
+		}
+		fmt.Printf("%s:\n", name)
+		fmt.Printf("\tController: %s://%s:%s\n", p.Scheme, p.Addr, portPrint)
+		fmt.Printf("\tGroups: %v\n", p.Groups)
+		if p.TestURL != "" {
+			fmt.Printf("\tTestURL: %s\n", p.TestURL)
+		}
+		if p.Filter != "" {
+			fmt.Printf("\tFilter: %s\n", p.Filter)
+		}
+		if len(p.Favorites) > 0 {
+			fmt.Printf("\tFavorites: %v\n", p.Favorites)
+		}
+		fmt.Println()
+	}
+}
+
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+func failf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	panic(&exitError{code: code})
+}
+
+func isTimeoutErr(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func classifyAPIErr(err error) int {
+	if isTimeoutErr(err) {
+		return ExitTimeout
+	}
+	return ExitControllerError
 }
 
 func main() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		switch v := r.(type) {
+		case *exitError:
+			os.Exit(v.code)
+		case string:
+			// Already printed to stderr by log.Panicf.
+			os.Exit(ExitBadInput)
+		case error:
+			log.Println(v.Error())
+			os.Exit(classifyAPIErr(v))
+		default:
+			panic(r)
+		}
+	}()
+
+	if len(os.Args) > 1 && os.Args[1] == "profiles" {
+		fs := flag.NewFlagSet("profiles", flag.ExitOnError)
+		configFlag := fs.String("config", "", "Path to clashcli config file")
+		fs.Parse(os.Args[2:])
+		doProfilesCommand(*configFlag)
+		return
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(),
 			`Usage of %[1]s:
@@ -53,11 +227,30 @@ Environment variables will be overridden by command line arguments, flags and op
                         commas. E.g. "My Proxy,Video Media,3".
     CLASH_TEST_URL      Delay test URL. Defaults to
                         connectivitycheck.gstatic.com/generate_204 .
+    CLASH_FILTER        Regex matched against proxy names; only matching
+                        proxies are shown/tested.
+    CLASH_EXCLUDE_FILTER
+                        Regex matched against proxy names; matching
+                        proxies are hidden.
+    CLASH_EXCLUDE_TYPE  Comma-separated Clash proxy types to hide, e.g.
+                        "Shadowsocks,Direct".
+    CLASH_SECRET        Clash external controller secret, sent as an
+                        "Authorization: Bearer <secret>" header.
+    CLASHCLI_CONFIG     Path to the clashcli config file. Defaults to
+                        ~/.config/clashcli/config.yaml .
 
 Command line:
     %[1]s [-h|--help]
-    %[1]s [-p <port>] [-a <addr>] [-u <url>] [-e <scheme>] [-s|-t]
+    %[1]s [-p <port>] [-a <addr>] [-u <url>] [-e <scheme>] [-s|-t|-T]
+            [-c <concurrency>] [--max-delay <ms>] [--expected-status <codes>]
+            [-f <regex>] [-x <regex>] [--exclude-type <types>]
+            [-S <secret>] [--insecure] [--ca-file <path>]
+            [--json] [--node <name-or-index>] [--list]
+            [-P <profile>] [--config <path>]
             [<Group1> [<Group2> [<G3> ...]]]
+    %[1]s profiles [--config <path>]
+
+Exit codes: 0 success, 2 bad input, 3 controller error, 4 timeout.
 
 `, os.Args[0])
 		flag.PrintDefaults()
@@ -69,9 +262,49 @@ Command line:
 	var testURLFlag = flag.String("u", "", "Delay test URL")
 	var selectFlag = flag.Bool("s", false, "(Select) Use node select feature. This is the default feature")
 	var delayTestFlag = flag.Bool("t", false, "(delay Test) Use delay test feature. You can specify only 1 proxy group in this case")
+	var testAllFlag = flag.Bool("T", false, "(Test-all) Concurrently delay-test every proxy in the chosen group and print a ranked table. You can specify only 1 proxy group in this case")
+	flag.BoolVar(testAllFlag, "test-all", false, "Alias for -T")
+	var concurrencyFlag = flag.Int("c", 8, "Number of concurrent delay tests to run when using -T/--test-all")
+	flag.IntVar(concurrencyFlag, "concurrency", 8, "Alias for -c")
+	var maxDelayFlag = flag.Int("max-delay", -1, "When using -T/--test-all, hide nodes with a delay above this many milliseconds")
+	var expectedStatusFlag = flag.String("expected-status", "", "Comma-separated HTTP status codes considered successful by the delay test, e.g. \"200,204\"")
+	var autoSelectFlag = flag.Bool("auto-select", false, "When using -T/--test-all, automatically select the fastest reachable node via the Clash API")
+	var filterFlag = flag.String("f", "", "Regex filter: only show/test proxies whose name matches")
+	flag.StringVar(filterFlag, "filter", "", "Alias for -f")
+	var excludeFilterFlag = flag.String("x", "", "Regex exclude-filter: hide proxies whose name matches")
+	flag.StringVar(excludeFilterFlag, "exclude-filter", "", "Alias for -x")
+	var excludeTypeFlag = flag.String("exclude-type", "", "Comma-separated Clash proxy types to hide, e.g. \"Shadowsocks,Direct\"")
+	var secretFlag = flag.String("S", "", "Clash external controller secret, sent as a Bearer token")
+	flag.StringVar(secretFlag, "secret", "", "Alias for -S")
+	var insecureFlag = flag.Bool("insecure", false, "Skip TLS certificate verification when using the https scheme")
+	var caFileFlag = flag.String("ca-file", "", "Path to a PEM-encoded root CA bundle to trust when using the https scheme")
+	var jsonFlag = flag.Bool("json", false, "Run non-interactively and print a single JSON document to stdout instead of human-readable text")
+	var nodeFlag = flag.String("node", "", "Node name or index to select/test, for non-interactive use")
+	var listFlag = flag.Bool("list", false, "Only enumerate groups/proxies without selecting or testing anything")
+	var profileFlag = flag.String("P", "", "Name of the profile to use from the clashcli config file")
+	flag.StringVar(profileFlag, "profile", "", "Alias for -P")
+	var configPathFlag = flag.String("config", "", "Path to clashcli config file")
 
 	flag.Parse()
 
+	configPath := resolveConfigPath(*configPathFlag)
+	cf, err := loadConfigFile(configPath)
+	if err != nil {
+		log.Panicf("Can't load config file %s: %s\n", configPath, err.Error())
+	}
+	var profile Profile
+	if cf != nil {
+		profileName := *profileFlag
+		if profileName == "" {
+			profileName = "default"
+		}
+		if p, ok := cf.Profiles[profileName]; ok {
+			profile = p
+		} else if *profileFlag != "" {
+			log.Panicf("Profile %q not found in config file %s\n", *profileFlag, configPath)
+		}
+	}
+
 	if *portFlag != -1 && (*portFlag <= 0 || *portFlag > 65535) {
 		log.Panicf("Bad port: %d\n", *portFlag)
 	}
@@ -84,6 +317,8 @@ Command line:
 			if err != nil || *port <= 0 || *port > 65535 {
 				log.Panicf("Bad port: %s\n", portStr)
 			}
+		} else if profile.Port != nil {
+			port = profile.Port
 		} else {
 			port = nil // Try 9090, 9091, ...
 		}
@@ -92,6 +327,9 @@ Command line:
 	addr := *addrFlag
 	if addr == "" {
 		addr = os.Getenv("CLASH_ADDR")
+		if addr == "" {
+			addr = profile.Addr
+		}
 		if addr == "" {
 			addr = "127.0.0.1"
 		}
@@ -100,6 +338,9 @@ Command line:
 	scheme := *schemeFlag
 	if scheme == "" {
 		scheme = os.Getenv("CLASH_SCHEME")
+		if scheme == "" {
+			scheme = profile.Scheme
+		}
 		if scheme == "" {
 			scheme = "http"
 		}
@@ -109,14 +350,66 @@ Command line:
 		log.Panicf("Unsupported scheme: %s\n", scheme)
 	}
 
+	secret := *secretFlag
+	if secret == "" {
+		secret = os.Getenv("CLASH_SECRET")
+		if secret == "" {
+			secret = profile.Secret
+		}
+	}
+
 	testURL := *testURLFlag
 	if testURL == "" {
 		testURL = os.Getenv("CLASH_TEST_URL")
+		if testURL == "" {
+			testURL = profile.TestURL
+		}
 		if testURL == "" {
 			testURL = "http://connectivitycheck.gstatic.com/generate_204"
 		}
 	}
 
+	filterStr := *filterFlag
+	if filterStr == "" {
+		filterStr = os.Getenv("CLASH_FILTER")
+		if filterStr == "" {
+			filterStr = profile.Filter
+		}
+	}
+	var filter *regexp.Regexp
+	if filterStr != "" {
+		var err error
+		filter, err = regexp.Compile(filterStr)
+		if err != nil {
+			log.Panicf("Bad filter regex: %s\n", err.Error())
+		}
+	}
+
+	excludeFilterStr := *excludeFilterFlag
+	if excludeFilterStr == "" {
+		excludeFilterStr = os.Getenv("CLASH_EXCLUDE_FILTER")
+	}
+	var excludeFilter *regexp.Regexp
+	if excludeFilterStr != "" {
+		var err error
+		excludeFilter, err = regexp.Compile(excludeFilterStr)
+		if err != nil {
+			log.Panicf("Bad exclude-filter regex: %s\n", err.Error())
+		}
+	}
+
+	excludeTypeStr := *excludeTypeFlag
+	if excludeTypeStr == "" {
+		excludeTypeStr = os.Getenv("CLASH_EXCLUDE_TYPE")
+	}
+	excludeType := make(map[string]bool)
+	for _, t := range strings.Split(excludeTypeStr, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			excludeType[t] = true
+		}
+	}
+
 	feature := FeatureNone
 	featureNum := 0
 	if *selectFlag {
@@ -129,10 +422,19 @@ Command line:
 		feature = FeatureDelayTest
 	}
 
+	if *testAllFlag {
+		featureNum++
+		feature = FeatureDelayTestAll
+	}
+
 	if featureNum > 1 {
 		log.Panicf("Can't select more than one feature")
 	}
 
+	if *concurrencyFlag <= 0 {
+		log.Panicf("Bad concurrency: %d\n", *concurrencyFlag)
+	}
+
 	if feature == FeatureNone {
 		feature = FeatureSelect
 	}
@@ -148,43 +450,77 @@ Command line:
 			}
 		}
 	}
+	if len(groups) == 0 {
+		groups = profile.Groups
+	}
 
 	config := Config{
-		Port:    port,
-		Addr:    addr,
-		Scheme:  scheme,
-		Groups:  groups,
-		TestURL: testURL,
+		Port:           port,
+		Addr:           addr,
+		Scheme:         scheme,
+		Groups:         groups,
+		TestURL:        testURL,
+		Concurrency:    *concurrencyFlag,
+		MaxDelay:       *maxDelayFlag,
+		ExpectedStatus: *expectedStatusFlag,
+		AutoSelect:     *autoSelectFlag,
+		Filter:         filter,
+		ExcludeFilter:  excludeFilter,
+		ExcludeType:    excludeType,
+		Secret:         secret,
+		Insecure:       *insecureFlag,
+		CAFile:         *caFileFlag,
+		JSONOutput:     *jsonFlag,
+		Node:           *nodeFlag,
+		ListOnly:       *listFlag,
+		Favorites:      profile.Favorites,
 	}
 
-	portPrint := "<Not decided>"
-	if port != nil {
-		portPrint = fmt.Sprintf("%d", *port)
-	}
+	if !config.JSONOutput {
+		portPrint := "<Not decided>"
+		if port != nil {
+			portPrint = fmt.Sprintf("%d", *port)
+		}
 
-	fmt.Printf(
-		`Using:
+		fmt.Printf(
+			`Using:
     Clash external controller: %s://%s:%s
     Groups: %v
     TestURL: %s
 
 `,
-		scheme,
-		addr,
-		portPrint,
-		groups,
-		testURL,
-	)
+			scheme,
+			addr,
+			portPrint,
+			groups,
+			testURL,
+		)
+	}
+
+	if config.ListOnly {
+		doListGroups(&config)
+		return
+	}
 
 	switch feature {
 	case FeatureSelect:
-		fmt.Println("> Selecting Nodes")
-		fmt.Println()
+		if !config.JSONOutput {
+			fmt.Println("> Selecting Nodes")
+			fmt.Println()
+		}
 		doSelectNode(&config)
 	case FeatureDelayTest:
-		fmt.Println("> Doing Delay Test")
-		fmt.Println()
+		if !config.JSONOutput {
+			fmt.Println("> Doing Delay Test")
+			fmt.Println()
+		}
 		doDelayTest(&config)
+	case FeatureDelayTestAll:
+		if !config.JSONOutput {
+			fmt.Println("> Doing Delay Test on the whole group")
+			fmt.Println()
+		}
+		doDelayTestAll(&config)
 	}
 }
 
@@ -210,8 +546,18 @@ type (
 	}
 )
 
+// Group types that Clash's outbound-group taxonomy exposes via /proxies.
+// Any of these can nest further groups in their All list.
+var proxyGroupTypes = map[string]bool{
+	"Selector":    true,
+	"URLTest":     true,
+	"Fallback":    true,
+	"LoadBalance": true,
+	"Relay":       true,
+}
+
 func (p *ClashProxyOrGroup) isGroup() bool {
-	return p.Type == "Selector"
+	return proxyGroupTypes[p.Type]
 }
 
 func decidePort(config *Config) (int, error) {
@@ -219,47 +565,103 @@ func decidePort(config *Config) (int, error) {
 		return *config.Port, nil
 	}
 
-	c := &http.Client{
-		Timeout: 300 * time.Millisecond,
-	}
+	c := newAPIClient(config)
+	c.Timeout = 300 * time.Millisecond
 	for _, p := range []int{9090, 9091, 19090, 19091} {
-		fmt.Printf("Trying port %d...", p) // In go, fmt.Print* functions are not buffered
+		if !config.JSONOutput {
+			fmt.Printf("Trying port %d...", p) // In go, fmt.Print* functions are not buffered
+		}
 
-		r, err := c.Get(fmt.Sprintf("%s://%s:%d/", config.Scheme, config.Addr, p))
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s:%d/", config.Scheme, config.Addr, p), nil)
 		if err != nil {
-			fmt.Printf("FAIL(Response): %s\n", err.Error())
+			return 0, err
+		}
+		addAuthHeader(req, config)
+
+		r, err := c.Do(req)
+		if err != nil {
+			if !config.JSONOutput {
+				fmt.Printf("FAIL(Response): %s\n", err.Error())
+			}
 			continue
 		}
 
 		m := make(map[string]interface{})
 		err = json.NewDecoder(r.Body).Decode(&m)
 		if err != nil {
-			fmt.Printf("FAIL(Decoding): %s\n", err.Error())
+			if !config.JSONOutput {
+				fmt.Printf("FAIL(Decoding): %s\n", err.Error())
+			}
 			continue
 		}
 
 		v, ok := m["hello"]
 		if !ok || v != "clash" {
-			fmt.Printf("FAIL: not a Clash controller instance\n")
+			if !config.JSONOutput {
+				fmt.Printf("FAIL: not a Clash controller instance\n")
+			}
 			continue
 		}
 
-		fmt.Println("OK")
-		fmt.Println()
+		if !config.JSONOutput {
+			fmt.Println("OK")
+			fmt.Println()
+		}
 		return p, nil
 	}
 	return 0, errors.New("can't find a port that a Clash controller instance runs on")
 }
 
-func apiNewClient() *http.Client {
-	return &http.Client{
+// newAPIClient builds an *http.Client configured from config: request
+// timeout, and, for the https scheme, TLS verification/CA trust. Every
+// call site building a Clash API client should go through this helper so
+// timeout and TLS behavior stay consistent.
+func newAPIClient(config *Config) *http.Client {
+	c := &http.Client{
 		Timeout: 5 * time.Second,
 	}
+
+	if config.Scheme != "https" {
+		return c
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.Insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			log.Panicf("Can't read CA file %s: %s\n", config.CAFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Panicf("No certificates found in CA file %s\n", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	c.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+	return c
+}
+
+// addAuthHeader sets the Authorization header on req when config carries a
+// controller secret, matching Clash's Bearer-token external-controller auth.
+func addAuthHeader(req *http.Request, config *Config) {
+	if config.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Secret)
+	}
 }
 
-func apiGetGroups(baseURL string) ([]ClashProxyOrGroup, map[string]ClashProxyOrGroup, error) {
-	c := apiNewClient()
-	r, err := c.Get(baseURL + "/proxies")
+func apiGetGroups(config *Config, baseURL string) ([]ClashProxyOrGroup, map[string]ClashProxyOrGroup, error) {
+	c := newAPIClient(config)
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/proxies", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	addAuthHeader(req, config)
+
+	r, err := c.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -289,8 +691,8 @@ func apiGetGroups(baseURL string) ([]ClashProxyOrGroup, map[string]ClashProxyOrG
 	return l, pr.ProxiesAndGroups, nil
 }
 
-func apiSelectNode(baseURL string, groupName string, proxyName string) error {
-	c := apiNewClient()
+func apiSelectNode(config *Config, baseURL string, groupName string, proxyName string) error {
+	c := newAPIClient(config)
 
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(ClashSelectNodeRequest{
@@ -308,6 +710,7 @@ func apiSelectNode(baseURL string, groupName string, proxyName string) error {
 	if err != nil {
 		return err
 	}
+	addAuthHeader(req, config)
 
 	r, err := c.Do(req)
 	if err != nil {
@@ -320,8 +723,8 @@ func apiSelectNode(baseURL string, groupName string, proxyName string) error {
 	return nil
 }
 
-func apiDelayTest(baseURL string, proxyName string, testURL string, timeoutMillisec int) (int, error) {
-	c := apiNewClient()
+func apiDelayTest(config *Config, baseURL string, proxyName string, testURL string, timeoutMillisec int, expectedStatus string) (int, error) {
+	c := newAPIClient(config)
 	c.Timeout = 120 * time.Second
 
 	req, err := http.NewRequest(
@@ -332,10 +735,14 @@ func apiDelayTest(baseURL string, proxyName string, testURL string, timeoutMilli
 	if err != nil {
 		return -1, err
 	}
+	addAuthHeader(req, config)
 
 	q := req.URL.Query()
 	q.Add("timeout", fmt.Sprintf("%d", timeoutMillisec))
 	q.Add("url", testURL)
+	if expectedStatus != "" {
+		q.Add("expected-status", expectedStatus)
+	}
 	req.URL.RawQuery = q.Encode()
 
 	r, err := c.Do(req)
@@ -379,7 +786,7 @@ func mustGetNonEmptyValidGroupNames(config *Config, groups []ClashProxyOrGroup,
 	}
 
 	if len(config.Groups) != 0 {
-		panic(errors.New("no input group names match those from Clash controller"))
+		failf(ExitBadInput, "no input group names match those from Clash controller")
 	} else {
 		// Prompt user for input
 		for i, g := range groups {
@@ -411,7 +818,52 @@ func mustGetNonEmptyValidGroupNames(config *Config, groups []ClashProxyOrGroup,
 	return validInputGroupNames
 }
 
-func askUserForNode(prompt string, nameToProxyOrGroup map[string]ClashProxyOrGroup, currGroup *ClashProxyOrGroup, optional bool) string {
+// visibleProxyNames filters currGroup.All down to the proxies that pass
+// config's filter/exclude-filter/exclude-type constraints, preserving order,
+// so that displayed indexes and user input only ever refer to visible nodes.
+func visibleProxyNames(config *Config, currGroup *ClashProxyOrGroup, nameToProxyOrGroup map[string]ClashProxyOrGroup) []string {
+	visible := make([]string, 0, len(currGroup.All))
+	for _, name := range currGroup.All {
+		if config.Filter != nil && !config.Filter.MatchString(name) {
+			continue
+		}
+		if config.ExcludeFilter != nil && config.ExcludeFilter.MatchString(name) {
+			continue
+		}
+		if len(config.ExcludeType) > 0 {
+			if p, ok := nameToProxyOrGroup[name]; ok && config.ExcludeType[p.Type] {
+				continue
+			}
+		}
+		visible = append(visible, name)
+	}
+	return visible
+}
+
+func favoritesFirst(config *Config, groupName string, names []string) []string {
+	favs := config.Favorites[groupName]
+	if len(favs) == 0 {
+		return names
+	}
+	isFav := make(map[string]bool, len(favs))
+	for _, f := range favs {
+		isFav[f] = true
+	}
+	ordered := make([]string, 0, len(names))
+	for _, f := range favs {
+		if contains(names, f) {
+			ordered = append(ordered, f)
+		}
+	}
+	for _, n := range names {
+		if !isFav[n] {
+			ordered = append(ordered, n)
+		}
+	}
+	return ordered
+}
+
+func askUserForNode(prompt string, visibleNames []string, optional bool) string {
 	var userSelected string = ""
 	for {
 		fmt.Printf("%s: [Node name/Index] ", prompt)
@@ -427,12 +879,12 @@ func askUserForNode(prompt string, nameToProxyOrGroup map[string]ClashProxyOrGro
 			fmt.Println("You must specify a node.")
 			continue
 		}
-		if _, ok := nameToProxyOrGroup[line]; ok {
+		if i, err := strconv.Atoi(line); err == nil && i >= 0 && i < len(visibleNames) {
+			userSelected = visibleNames[i]
+		} else if contains(visibleNames, line) {
 			userSelected = line
-		} else if i, err := strconv.Atoi(line); err == nil && i >= 0 && i < len(currGroup.All) {
-			userSelected = currGroup.All[i]
 		} else {
-			fmt.Println("Bad input.")
+			fmt.Println("Bad input, or node is hidden by a filter.")
 			continue
 		}
 		break
@@ -440,15 +892,107 @@ func askUserForNode(prompt string, nameToProxyOrGroup map[string]ClashProxyOrGro
 	return userSelected
 }
 
+func contains(haystack []string, needle string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveNode(nameOrIndex string, visibleNames []string) string {
+	if contains(visibleNames, nameOrIndex) {
+		return nameOrIndex
+	}
+	if i, err := strconv.Atoi(nameOrIndex); err == nil && i >= 0 && i < len(visibleNames) {
+		return visibleNames[i]
+	}
+	return ""
+}
+
+type (
+	jsonProxyEntry struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Delay *int   `json:"delay,omitempty"`
+	}
+
+	jsonGroupEntry struct {
+		Name string           `json:"name"`
+		Now  string           `json:"now"`
+		All  []jsonProxyEntry `json:"all"`
+	}
+
+	jsonListResponse struct {
+		Groups []jsonGroupEntry `json:"groups"`
+	}
+
+	jsonSelectResponse struct {
+		Group    string `json:"group"`
+		Selected string `json:"selected"`
+		OK       bool   `json:"ok"`
+	}
+)
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+func doListGroups(config *Config) {
+	baseURL := mustDecideBaseURL(config)
+	groups, nameToProxyOrGroup, err := apiGetGroups(config, baseURL)
+	if err != nil {
+		panic(err)
+	}
+
+	resp := jsonListResponse{Groups: make([]jsonGroupEntry, 0, len(groups))}
+	for _, g := range groups {
+		visible := visibleProxyNames(config, &g, nameToProxyOrGroup)
+
+		if !config.JSONOutput {
+			fmt.Printf("[Group %s] Now: %s\n", g.Name, g.Now)
+			for i, p := range visible {
+				fmt.Printf("%d.\t%s\n", i, p)
+			}
+			fmt.Println()
+			continue
+		}
+
+		entries := make([]jsonProxyEntry, 0, len(visible))
+		for _, p := range visible {
+			entries = append(entries, jsonProxyEntry{Name: p, Type: nameToProxyOrGroup[p].Type})
+		}
+		resp.Groups = append(resp.Groups, jsonGroupEntry{Name: g.Name, Now: g.Now, All: entries})
+	}
+
+	if config.JSONOutput {
+		printJSON(resp)
+	}
+}
+
 func doSelectNode(config *Config) {
 	baseURL := mustDecideBaseURL(config)
-	groups, nameToProxyOrGroup, err := apiGetGroups(baseURL)
+	groups, nameToProxyOrGroup, err := apiGetGroups(config, baseURL)
 	if err != nil {
 		panic(err)
 	}
+
+	nonInteractive := config.JSONOutput || config.Node != ""
+	if nonInteractive && len(config.Groups) == 0 {
+		failf(ExitBadInput, "must specify a group in non-interactive (--json/--node) mode")
+	}
 	validInputGroupNames := mustGetNonEmptyValidGroupNames(config, groups, nameToProxyOrGroup)
 
 	for _, g := range validInputGroupNames {
+		if !nonInteractive {
+			navigateGroup(config, baseURL, g, nameToProxyOrGroup, make(map[string]bool))
+			continue
+		}
+
 		currGroup, ok := nameToProxyOrGroup[g]
 		if !ok {
 			panic(fmt.Errorf("%s not in currGroup", g))
@@ -456,69 +1000,316 @@ func doSelectNode(config *Config) {
 		if !currGroup.isGroup() {
 			panic(fmt.Errorf("currGroup.isGroup() is false"))
 		}
-		fmt.Printf("[Group %s]\n", g)
-		for i, p := range currGroup.All {
-			fmt.Printf("%d.\t%s\n", i, p)
+		visible := visibleProxyNames(config, &currGroup, nameToProxyOrGroup)
+
+		if config.Node == "" {
+			failf(ExitBadInput, "must specify --node in non-interactive (--json) mode")
+		}
+		userSelected := resolveNode(config.Node, visible)
+		if userSelected == "" {
+			failf(ExitBadInput, "node %q not found in group %s, or hidden by a filter", config.Node, g)
+		}
+
+		if !config.JSONOutput {
+			fmt.Printf("Selecting %s for group %s...", userSelected, g)
+		}
+		err := apiSelectNode(config, baseURL, g, userSelected)
+		if err != nil {
+			failf(classifyAPIErr(err), "Stop, because error encountered selecting %s for group %s: %s", userSelected, g, err.Error())
+		}
+
+		if config.JSONOutput {
+			printJSON(jsonSelectResponse{Group: g, Selected: userSelected, OK: true})
+		} else {
+			fmt.Println("OK")
+		}
+	}
+}
+
+// navigateGroup drives the interactive menu for groupName, letting the user
+// select one of its visible proxies or drill into a nested group with the
+// ">index"/">name" token. Leaving a nested group (empty input) restores the
+// parent's menu. visited guards against cycles in the group graph.
+func navigateGroup(config *Config, baseURL string, groupName string, nameToProxyOrGroup map[string]ClashProxyOrGroup, visited map[string]bool) {
+	if visited[groupName] {
+		fmt.Printf("Group %s was already visited on this path, skipping to avoid a cycle.\n", groupName)
+		return
+	}
+	visited[groupName] = true
+	defer delete(visited, groupName)
+
+	currGroup, ok := nameToProxyOrGroup[groupName]
+	if !ok {
+		panic(fmt.Errorf("%s not in currGroup", groupName))
+	}
+	if !currGroup.isGroup() {
+		panic(fmt.Errorf("currGroup.isGroup() is false"))
+	}
+	visible := favoritesFirst(config, groupName, visibleProxyNames(config, &currGroup, nameToProxyOrGroup))
+
+	for {
+		fmt.Printf("[Group %s (%s)]\n", groupName, currGroup.Type)
+		for i, p := range visible {
+			marker := ""
+			if contains(config.Favorites[groupName], p) {
+				marker = "★ "
+			}
+			suffix := ""
+			if po, ok := nameToProxyOrGroup[p]; ok && po.isGroup() {
+				suffix = fmt.Sprintf(" [%s group, drill in with >%d]", po.Type, i)
+			}
+			fmt.Printf("%d.\t%s%s%s\n", i, marker, p, suffix)
 		}
-		fmt.Printf("\nCurrent group: %s\n", g)
 		nowIndex := -1
-		for i, v := range currGroup.All {
+		for i, v := range visible {
 			if v == currGroup.Now {
 				nowIndex = i
 				break
 			}
 		}
+		fmt.Printf("\nCurrent group: %s\n", groupName)
 		fmt.Printf("Currently selected: %d. %s\n\n", nowIndex, currGroup.Now)
 
-		userSelected := askUserForNode("Select a node", nameToProxyOrGroup, &currGroup, true)
-		if userSelected == "" {
+		fmt.Printf("Select a node, or >Index/>Name to drill into a sub-group: [Node name/Index] ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			panic(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
 			fmt.Println("Not selecting for this group.")
+			return
+		}
+
+		if strings.HasPrefix(line, ">") {
+			target := resolveNode(strings.TrimPrefix(line, ">"), visible)
+			if target == "" {
+				fmt.Println("Bad input, or node is hidden by a filter.")
+				continue
+			}
+			if po, ok := nameToProxyOrGroup[target]; !ok || !po.isGroup() {
+				fmt.Printf("%s is not a group, can't drill in.\n", target)
+				continue
+			}
+			navigateGroup(config, baseURL, target, nameToProxyOrGroup, visited)
+			continue
+		}
+
+		userSelected := resolveNode(line, visible)
+		if userSelected == "" {
+			fmt.Println("Bad input, or node is hidden by a filter.")
 			continue
 		}
 
-		fmt.Printf("Selecting %s for group %s...", userSelected, g)
-		err := apiSelectNode(baseURL, g, userSelected)
+		fmt.Printf("Selecting %s for group %s...", userSelected, groupName)
+		err = apiSelectNode(config, baseURL, groupName, userSelected)
 		if err != nil {
-			fmt.Printf("FAIL: %s\n", err.Error())
-			log.Printf("\nStop, because error encountered: %s\n", err.Error())
-			return
+			failf(classifyAPIErr(err), "Stop, because error encountered selecting %s for group %s: %s", userSelected, groupName, err.Error())
 		}
 		fmt.Println("OK")
 		fmt.Println()
+		return
 	}
 }
 
 func doDelayTest(config *Config) {
 	baseURL := mustDecideBaseURL(config)
-	groups, nameToProxyOrGroup, err := apiGetGroups(baseURL)
+	nonInteractive := config.JSONOutput || config.Node != ""
+	if nonInteractive && len(config.Groups) == 0 {
+		failf(ExitBadInput, "must specify a group in non-interactive (--json/--node) mode")
+	}
+
+	groups, nameToProxyOrGroup, err := apiGetGroups(config, baseURL)
 	if err != nil {
 		panic(err)
 	}
 	validInputGroupNames := mustGetNonEmptyValidGroupNames(config, groups, nameToProxyOrGroup)
-	if len(validInputGroupNames) > 1 {
+	if len(validInputGroupNames) > 1 && !config.JSONOutput {
 		fmt.Println("Only one group allowed when you are doing delay test. Picking the first one")
 	}
 
-	fmt.Println()
-
 	g := validInputGroupNames[0]
 	currGroup, ok := nameToProxyOrGroup[g]
 	if !ok {
 		panic(fmt.Errorf("%s not in currGroup", g))
 	}
-	fmt.Printf("[Group %s]\n", g)
-	for i, p := range currGroup.All {
-		fmt.Printf("%d.\t%s\n", i, p)
+	visible := visibleProxyNames(config, &currGroup, nameToProxyOrGroup)
+
+	var userSelected string
+	if nonInteractive {
+		if config.Node == "" {
+			failf(ExitBadInput, "must specify --node in non-interactive (--json) mode")
+		}
+		userSelected = resolveNode(config.Node, visible)
+		if userSelected == "" {
+			failf(ExitBadInput, "node %q not found in group %s, or hidden by a filter", config.Node, g)
+		}
+	} else {
+		fmt.Println()
+		fmt.Printf("[Group %s]\n", g)
+		for i, p := range visible {
+			fmt.Printf("%d.\t%s\n", i, p)
+		}
+		userSelected = askUserForNode("\nSelect a node to test", visible, false)
+		fmt.Printf("Testing %s...", userSelected)
 	}
 
-	userSelected := askUserForNode("\nSelect a node to test", nameToProxyOrGroup, &currGroup, false)
+	delay, err := apiDelayTest(config, baseURL, userSelected, config.TestURL, 5000, config.ExpectedStatus)
+	if err != nil {
+		failf(classifyAPIErr(err), "Stop, because error encountered testing %s: %s", userSelected, err.Error())
+	}
+
+	if config.JSONOutput {
+		printJSON(jsonListResponse{Groups: []jsonGroupEntry{{
+			Name: g,
+			Now:  currGroup.Now,
+			All:  []jsonProxyEntry{{Name: userSelected, Type: nameToProxyOrGroup[userSelected].Type, Delay: &delay}},
+		}}})
+	} else {
+		fmt.Printf("%d ms\n", delay)
+	}
+}
+
+type proxyDelayResult struct {
+	Name  string
+	Delay int
+	Err   error
+}
 
-	fmt.Printf("Testing %s...", userSelected)
-	delay, err := apiDelayTest(baseURL, userSelected, config.TestURL, 5000)
+// runGroupDelayTest tests every proxy in names concurrently, bounded by
+// concurrency workers, and returns one result per proxy in unspecified order.
+// If onResult is non-nil, it is called as each proxy's test completes so
+// callers can render a live progress view instead of waiting for the batch.
+func runGroupDelayTest(config *Config, baseURL string, names []string, testURL string, timeoutMillisec int, expectedStatus string, concurrency int, onResult func(proxyDelayResult)) []proxyDelayResult {
+	jobs := make(chan string)
+	results := make([]proxyDelayResult, len(names))
+	var resultsMu sync.Mutex
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				delay, err := apiDelayTest(config, baseURL, name, testURL, timeoutMillisec, expectedStatus)
+				result := proxyDelayResult{Name: name, Delay: delay, Err: err}
+				resultsMu.Lock()
+				for i, n := range names {
+					if n == name {
+						results[i] = result
+						break
+					}
+				}
+				resultsMu.Unlock()
+				if onResult != nil {
+					onResult(result)
+				}
+			}
+		}()
+	}
+
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func doDelayTestAll(config *Config) {
+	baseURL := mustDecideBaseURL(config)
+	if config.JSONOutput && len(config.Groups) == 0 {
+		failf(ExitBadInput, "must specify a group in non-interactive (--json) mode")
+	}
+	groups, nameToProxyOrGroup, err := apiGetGroups(config, baseURL)
 	if err != nil {
-		fmt.Printf("FAIL: %s\n", err.Error())
-		log.Printf("\nStop, because error encountered: %s\n", err.Error())
-		return
+		panic(err)
+	}
+	validInputGroupNames := mustGetNonEmptyValidGroupNames(config, groups, nameToProxyOrGroup)
+	if len(validInputGroupNames) > 1 && !config.JSONOutput {
+		fmt.Println("Only one group allowed when you are doing delay test. Picking the first one")
+	}
+
+	g := validInputGroupNames[0]
+	currGroup, ok := nameToProxyOrGroup[g]
+	if !ok {
+		panic(fmt.Errorf("%s not in currGroup", g))
+	}
+	visible := visibleProxyNames(config, &currGroup, nameToProxyOrGroup)
+	if !config.JSONOutput {
+		fmt.Printf("\n[Group %s] Testing %d node(s) with %d worker(s)...\n\n", g, len(visible), config.Concurrency)
+	}
+
+	var onResult func(proxyDelayResult)
+	if !config.JSONOutput {
+		onResult = func(r proxyDelayResult) {
+			if r.Err != nil {
+				fmt.Printf("...\t%s\tunreachable (%s)\n", r.Name, r.Err.Error())
+			} else {
+				fmt.Printf("...\t%s\t%d ms\n", r.Name, r.Delay)
+			}
+		}
+	}
+	results := runGroupDelayTest(config, baseURL, visible, config.TestURL, 5000, config.ExpectedStatus, config.Concurrency, onResult)
+
+	if !config.JSONOutput {
+		fmt.Println("\nRanked results:")
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		iUnreachable := results[i].Err != nil
+		jUnreachable := results[j].Err != nil
+		if iUnreachable != jUnreachable {
+			return !iUnreachable
+		}
+		return results[i].Delay < results[j].Delay
+	})
+
+	fastest := ""
+	rank := 0
+	entries := make([]jsonProxyEntry, 0, len(results))
+	for _, r := range results {
+		if r.Err == nil && config.MaxDelay > 0 && r.Delay > config.MaxDelay {
+			continue
+		}
+		rank++
+		if r.Err != nil {
+			if !config.JSONOutput {
+				fmt.Printf("%d.\t%s\tunreachable (%s)\n", rank, r.Name, r.Err.Error())
+			}
+			entries = append(entries, jsonProxyEntry{Name: r.Name, Type: nameToProxyOrGroup[r.Name].Type})
+			continue
+		}
+		if !config.JSONOutput {
+			fmt.Printf("%d.\t%s\t%d ms\n", rank, r.Name, r.Delay)
+		}
+		delay := r.Delay
+		entries = append(entries, jsonProxyEntry{Name: r.Name, Type: nameToProxyOrGroup[r.Name].Type, Delay: &delay})
+		if fastest == "" {
+			fastest = r.Name
+		}
+	}
+
+	if config.JSONOutput {
+		printJSON(jsonListResponse{Groups: []jsonGroupEntry{{Name: g, Now: currGroup.Now, All: entries}}})
+	}
+
+	if config.AutoSelect {
+		if fastest == "" {
+			if !config.JSONOutput {
+				fmt.Println("\nNo reachable node found, not auto-selecting.")
+			}
+			return
+		}
+		if !config.JSONOutput {
+			fmt.Printf("\nAuto-selecting fastest node %s for group %s...", fastest, g)
+		}
+		err := apiSelectNode(config, baseURL, g, fastest)
+		if err != nil {
+			failf(classifyAPIErr(err), "Stop, because error encountered auto-selecting %s for group %s: %s", fastest, g, err.Error())
+		}
+		if !config.JSONOutput {
+			fmt.Println("OK")
+		}
 	}
-	fmt.Printf("%d ms\n", delay)
 }